@@ -0,0 +1,205 @@
+package tgz
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// ProgressEvent describes the state of an in-progress Pack/UnpackContext
+// call, as reported to a Progress callback after each archive entry.
+type ProgressEvent struct {
+	// CurrentPath is the archive-relative path of the entry that was just
+	// processed.
+	CurrentPath string
+
+	// BytesDone is the number of bytes read so far. For PackContext this
+	// counts bytes read from source files on disk; for UnpackContext it
+	// counts compressed bytes read from the archive stream, since that is
+	// the only total known up front (see BytesTotal).
+	BytesDone int64
+
+	// BytesTotal is the total byte count BytesDone is measured against.
+	// For PackContext it is the sum of regular file sizes found by a
+	// first filepath.Walk pass. For UnpackContext it is the archive's
+	// on-disk size from os.Stat, or 0 when unknown (as from a reader with
+	// no underlying file, e.g. UnpackFromReader-style input).
+	BytesTotal int64
+
+	// FilesDone is the number of entries processed so far.
+	FilesDone int
+
+	// FilesTotal is the total entry count found by PackContext's
+	// filepath.Walk pass. UnpackContext leaves it at 0, since an
+	// archive's entry count isn't known without reading the whole stream.
+	FilesTotal int
+}
+
+// Progress is called by PackContext and UnpackContext after each archive
+// entry is processed. Implementations should return quickly, since they run
+// synchronously on the pack/unpack goroutine.
+type Progress func(ProgressEvent)
+
+// PackContext creates a .tar.gz archive from the source directory (sourceDir)
+// and saves it to targetArchive, exactly like PackWithPrefixOptions, except
+// that ctx is checked between entries (a cancelled ctx aborts with ctx.Err()
+// at the next entry) and progress, if non-nil, is called after each entry
+// with running totals. BytesTotal and FilesTotal are precomputed with a
+// first filepath.Walk pass over sourceDir before any archive data is
+// written; that pass is skipped, and they stay 0, when progress is nil.
+func PackContext(ctx context.Context, sourceDir, targetArchive, prefix string, level int, opts PackOptions, progress Progress) error {
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return fmt.Errorf("source directory does not exist: %v", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("source is not a directory")
+	}
+
+	tarFile, err := os.Create(targetArchive)
+	if err != nil {
+		return fmt.Errorf("could not create archive file: %v", err)
+	}
+	defer tarFile.Close()
+
+	var bytesTotal int64
+	var filesTotal int
+	if progress != nil {
+		bytesTotal, filesTotal, err = packTotals(sourceDir, opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	var writerOpts []WriterOption
+	if opts.Deterministic {
+		writerOpts = append(writerOpts, WriterWithDeterministic(opts.SourceDateEpoch))
+	}
+
+	archiveWriter, err := NewWriter(tarFile, level, writerOpts...)
+	if err != nil {
+		return err
+	}
+	defer archiveWriter.Close()
+
+	var filesDone int
+	var bytesDone int64
+
+	return walkPackEntries(sourceDir, opts, func(archiveRelPath, filePath string, fileInfo os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := addArchiveEntryContext(ctx, archiveWriter, prefix, archiveRelPath, filePath, fileInfo, &bytesDone); err != nil {
+			return err
+		}
+
+		filesDone++
+		if progress != nil {
+			progress(ProgressEvent{
+				CurrentPath: archiveRelPath,
+				BytesDone:   bytesDone,
+				BytesTotal:  bytesTotal,
+				FilesDone:   filesDone,
+				FilesTotal:  filesTotal,
+			})
+		}
+
+		return nil
+	})
+}
+
+// packTotals walks sourceDir, applying opts exactly as packDirToWriter would,
+// and returns the total byte count of regular files and the total entry
+// count that PackContext would archive. It is the "first filepath.Walk pass"
+// PackContext uses to populate ProgressEvent.BytesTotal/FilesTotal.
+func packTotals(sourceDir string, opts PackOptions) (bytesTotal int64, filesTotal int, err error) {
+	err = walkPackEntries(sourceDir, opts, func(archiveRelPath, filePath string, fileInfo os.FileInfo) error {
+		filesTotal++
+		if fileInfo.Mode().IsRegular() {
+			bytesTotal += fileInfo.Size()
+		}
+		return nil
+	})
+	return bytesTotal, filesTotal, err
+}
+
+// addArchiveEntryContext writes a single entry to archiveWriter exactly like
+// addArchiveEntry, except that a regular file's content is copied through a
+// progressReader so bytesDone accumulates the bytes read from filePath and
+// ctx can interrupt a large copy between reads.
+func addArchiveEntryContext(ctx context.Context, archiveWriter *Writer, prefix, archiveRelPath, filePath string, fileInfo os.FileInfo, bytesDone *int64) error {
+	if !fileInfo.Mode().IsRegular() {
+		return addArchiveEntry(archiveWriter, prefix, archiveRelPath, filePath, fileInfo)
+	}
+
+	name := filepath.ToSlash(applyPrefix(archiveRelPath, prefix))
+
+	entryWriter, err := archiveWriter.AddFile(name, fileInfo)
+	if err != nil {
+		return err
+	}
+
+	// A hardlink AddFile has already seen under another name carries no
+	// content of its own.
+	if entryWriter == nil {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(entryWriter, &progressReader{r: file, ctx: ctx, bytesRead: bytesDone})
+	return err
+}
+
+// UnpackContext extracts the archive at sourceArchive into targetDir, exactly
+// like UnpackWithOptions, except that ctx is checked between entries (a
+// cancelled ctx aborts with ctx.Err() at the next entry) and progress, if
+// non-nil, is called after each entry with running totals. BytesTotal is the
+// archive's on-disk size from os.Stat; BytesDone tracks compressed bytes read
+// from that file, not the uncompressed bytes written to disk, since that is
+// the only total known without decompressing the archive up front.
+// FilesTotal is always left at 0.
+func UnpackContext(ctx context.Context, sourceArchive, targetDir string, opts UnpackOptions, progress Progress) error {
+	file, err := os.Open(sourceArchive)
+	if err != nil {
+		return fmt.Errorf("could not open archive file: %v", err)
+	}
+	defer file.Close()
+
+	var bytesTotal int64
+	if info, err := file.Stat(); err == nil {
+		bytesTotal = info.Size()
+	}
+
+	return unpackAnyWithOptions(ctx, file, targetDir, opts, progress, bytesTotal)
+}
+
+// progressReader wraps r, reporting ctx.Err() (if any) before each Read and
+// accumulating the number of bytes read into bytesRead so a caller can
+// surface it via a Progress callback without serializing access itself;
+// Pack/UnpackContext each only ever read through one progressReader at a
+// time, but atomic keeps bytesRead safe to read concurrently from Progress.
+type progressReader struct {
+	r         io.Reader
+	ctx       context.Context
+	bytesRead *int64
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.r.Read(buf)
+	atomic.AddInt64(p.bytesRead, int64(n))
+	return n, err
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package tgz
+
+import (
+	"archive/tar"
+	"fmt"
+	"syscall"
+)
+
+// makedev packs a device's major/minor numbers into the dev_t value the
+// Linux mknod(2) syscall expects, following the same glibc bit layout as
+// sys/sysmacros.h's makedev.
+func makedev(major, minor int64) uint64 {
+	return (uint64(major&0xfff) << 8) |
+		(uint64(minor & 0xff)) |
+		((uint64(major) &^ 0xfff) << 32) |
+		((uint64(minor) &^ 0xff) << 12)
+}
+
+// createDevice creates the character device, block device, or named pipe
+// described by header at targetPath.
+func createDevice(targetPath string, header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeFifo:
+		return syscall.Mkfifo(targetPath, uint32(header.Mode))
+
+	case tar.TypeChar:
+		return syscall.Mknod(targetPath, syscall.S_IFCHR|uint32(header.Mode), int(makedev(header.Devmajor, header.Devminor)))
+
+	case tar.TypeBlock:
+		return syscall.Mknod(targetPath, syscall.S_IFBLK|uint32(header.Mode), int(makedev(header.Devmajor, header.Devminor)))
+
+	default:
+		return fmt.Errorf("unsupported device type %q", header.Typeflag)
+	}
+}
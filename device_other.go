@@ -0,0 +1,17 @@
+//go:build !linux
+
+package tgz
+
+import (
+	"archive/tar"
+	"fmt"
+	"runtime"
+)
+
+// createDevice creates the character device, block device, or named pipe
+// described by header at targetPath. Device-number packing (mknod's
+// major/minor encoding) is platform-specific and only implemented for
+// Linux, so this always fails.
+func createDevice(targetPath string, header *tar.Header) error {
+	return fmt.Errorf("creating device nodes is not supported on %s", runtime.GOOS)
+}
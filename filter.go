@@ -0,0 +1,157 @@
+package tgz
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// PackOptions controls which entries of a source directory are archived by
+// PackWithPrefixOptions (and, via the With* Option functions, PackToWriter).
+type PackOptions struct {
+	// Include, if non-empty, restricts the archive to entries whose
+	// path relative to sourceDir matches at least one pattern.
+	// Directories are always traversed and archived regardless of
+	// Include, so a deeper matching file is still found.
+	Include []string
+
+	// Exclude skips entries whose relative path matches any pattern.
+	// Matching directories are pruned from the walk entirely rather
+	// than walked and discarded.
+	Exclude []string
+
+	// FollowSymlinks makes the walk descend into symlinked directories
+	// (and archive symlinked regular files by their target's content)
+	// instead of archiving the symlink entry itself.
+	FollowSymlinks bool
+
+	// IgnoreFile, if set, names a gitignore-style file (e.g.
+	// ".tgzignore") read from each directory; non-empty, non-comment
+	// lines in it are patterns that additionally exclude sibling
+	// entries of that same directory.
+	IgnoreFile string
+
+	// Deterministic, when true, produces a byte-identical archive across
+	// runs and machines for a given input tree: entries are visited in
+	// lexicographic order (already the default under filepath.Walk),
+	// ModTime is clamped to SourceDateEpoch, AccessTime/ChangeTime are
+	// zeroed, ownership is normalized to uid/gid 0 ("root"), and modes
+	// are masked to 0644/0755. See WriterWithDeterministic.
+	Deterministic bool
+
+	// SourceDateEpoch is the Unix timestamp used for every entry's
+	// ModTime when Deterministic is set. See
+	// https://reproducible-builds.org/specs/source-date-epoch/.
+	SourceDateEpoch int64
+}
+
+// isExcluded reports whether the entry at filePath (whose path relative to
+// the archive root is relPath) should be skipped, per opts.Exclude and any
+// applicable opts.IgnoreFile.
+func isExcluded(filePath, relPath string, opts PackOptions, ignoreCache map[string][]string) bool {
+	if matchesAny(relPath, opts.Exclude) {
+		return true
+	}
+
+	if opts.IgnoreFile == "" {
+		return false
+	}
+
+	dir := filepath.Dir(filePath)
+	base := filepath.Base(filePath)
+
+	for _, pattern := range loadIgnorePatterns(dir, opts.IgnoreFile, ignoreCache) {
+		if matchGlob(pattern, base) || matchGlob(pattern, relPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadIgnorePatterns reads name from dir, if present, and returns its
+// non-empty, non-comment lines as glob patterns. Results are cached per
+// directory so each ignore file is only read and parsed once.
+func loadIgnorePatterns(dir, name string, cache map[string][]string) []string {
+	if patterns, ok := cache[dir]; ok {
+		return patterns
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		cache[dir] = nil
+		return nil
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	cache[dir] = patterns
+	return patterns
+}
+
+// matchesAny reports whether path matches at least one of patterns.
+func matchesAny(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob reports whether path matches a shell-style glob pattern. Unlike
+// a plain filepath.Match, "**" is additionally allowed to match zero or more
+// path segments, including across "/" separators, as in doublestar and
+// gitignore patterns. In a pattern containing "**", "[...]" classes and "\"
+// escapes are not interpreted and match literally.
+func matchGlob(pattern, path string) bool {
+	if !strings.Contains(pattern, "**") {
+		ok, _ := filepath.Match(pattern, path)
+		return ok
+	}
+
+	var re strings.Builder
+	re.WriteByte('^')
+
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 3
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			re.WriteString("[^/]*")
+			i++
+		case pattern[i] == '?':
+			re.WriteString("[^/]")
+			i++
+		case strings.ContainsRune(`.+()^$|{}[]\`, rune(pattern[i])):
+			re.WriteByte('\\')
+			re.WriteByte(pattern[i])
+			i++
+		default:
+			re.WriteByte(pattern[i])
+			i++
+		}
+	}
+
+	re.WriteByte('$')
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return false
+	}
+
+	return compiled.MatchString(path)
+}
@@ -0,0 +1,364 @@
+package tgz
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// PackFormat identifies an archive/compression format that PackAs can
+// produce.
+type PackFormat int
+
+const (
+	// FormatTarGz is a gzip-compressed tar archive (.tar.gz).
+	FormatTarGz PackFormat = iota
+	// FormatTarZst is a zstd-compressed tar archive (.tar.zst).
+	FormatTarZst
+	// FormatTarXz is an xz-compressed tar archive (.tar.xz).
+	FormatTarXz
+	// FormatZip is a zip archive (.zip).
+	FormatZip
+)
+
+// compression identifies the decompressor Unpack should use for an archive,
+// as determined by sniffing its leading bytes.
+type compression int
+
+const (
+	compressionNone compression = iota
+	compressionGzip
+	compressionBzip2
+	compressionXz
+	compressionZstd
+	compressionZip
+)
+
+// Magic byte sequences used to autodetect an archive's compression, per the
+// format signatures documented at https://en.wikipedia.org/wiki/List_of_file_signatures.
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte{0x42, 0x5a, 0x68}
+	magicXz    = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	magicZip   = []byte{0x50, 0x4b, 0x03, 0x04}
+)
+
+// sniffLen is the number of leading bytes peeked to detect a compression
+// format; it only needs to cover the longest magic sequence above.
+const sniffLen = 6
+
+// detectCompression inspects the leading bytes of an archive and reports
+// which compression/container format produced them. Anything that matches
+// none of the known magic numbers is assumed to be a plain, uncompressed tar
+// stream.
+func detectCompression(header []byte) compression {
+	switch {
+	case bytes.HasPrefix(header, magicGzip):
+		return compressionGzip
+	case bytes.HasPrefix(header, magicBzip2):
+		return compressionBzip2
+	case bytes.HasPrefix(header, magicXz):
+		return compressionXz
+	case bytes.HasPrefix(header, magicZstd):
+		return compressionZstd
+	case bytes.HasPrefix(header, magicZip):
+		return compressionZip
+	default:
+		return compressionNone
+	}
+}
+
+// unpackAnyWithOptions sniffs the compression/container format of the
+// archive read from r and extracts it into targetDir, honoring opts. It
+// backs Unpack, UnpackWithOptions, and UnpackFromReader. ctx is checked
+// between entries; progress, if non-nil, is reported after each entry with
+// BytesDone tracking compressed bytes read from r against bytesTotal (the
+// archive's on-disk size, or 0 when unknown, as from UnpackFromReader).
+func unpackAnyWithOptions(ctx context.Context, r io.Reader, targetDir string, opts UnpackOptions, progress Progress, bytesTotal int64) error {
+	var bytesRead int64
+	if progress != nil {
+		r = &progressReader{r: r, ctx: ctx, bytesRead: &bytesRead}
+	}
+
+	br := bufio.NewReader(r)
+
+	peeked, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return fmt.Errorf("could not read archive header: %v", err)
+	}
+
+	switch detectCompression(peeked) {
+	case compressionZip:
+		// zip.NewReader needs an io.ReaderAt and a known size, so the
+		// archive must be buffered in full rather than streamed.
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return fmt.Errorf("could not read zip archive: %v", err)
+		}
+		return unpackZipWithOptions(ctx, bytes.NewReader(data), int64(len(data)), targetDir, opts, progress, &bytesRead, bytesTotal)
+
+	case compressionGzip:
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("could not create gzip reader: %v", err)
+		}
+		defer gzipReader.Close()
+		return unpackTarEntries(ctx, tar.NewReader(gzipReader), targetDir, opts, progress, &bytesRead, bytesTotal)
+
+	case compressionBzip2:
+		return unpackTarEntries(ctx, tar.NewReader(bzip2.NewReader(br)), targetDir, opts, progress, &bytesRead, bytesTotal)
+
+	case compressionXz:
+		xzReader, err := xz.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("could not create xz reader: %v", err)
+		}
+		return unpackTarEntries(ctx, tar.NewReader(xzReader), targetDir, opts, progress, &bytesRead, bytesTotal)
+
+	case compressionZstd:
+		zstdReader, err := zstd.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("could not create zstd reader: %v", err)
+		}
+		defer zstdReader.Close()
+		return unpackTarEntries(ctx, tar.NewReader(zstdReader), targetDir, opts, progress, &bytesRead, bytesTotal)
+
+	default:
+		return unpackTarEntries(ctx, tar.NewReader(br), targetDir, opts, progress, &bytesRead, bytesTotal)
+	}
+}
+
+// unpackZipWithOptions extracts the zip archive read from r (of the given
+// size) into targetDir, honoring opts. Unlike tar-based formats it does not
+// support symlinks or hardlinks, which zip represents as regular files
+// rather than a dedicated entry type. ctx is checked between entries;
+// progress, if non-nil, is reported after each entry, reading bytesRead for
+// ProgressEvent.BytesDone against bytesTotal (see progressReader in
+// context.go).
+func unpackZipWithOptions(ctx context.Context, r io.ReaderAt, size int64, targetDir string, opts UnpackOptions, progress Progress, bytesRead *int64, bytesTotal int64) error {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("could not create zip reader: %v", err)
+	}
+
+	var filesDone int
+	var maxSizeBytesDone int64
+
+	for _, zipFile := range zipReader.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		filesDone++
+		if opts.MaxFiles > 0 && filesDone > opts.MaxFiles {
+			return fmt.Errorf("archive contains more than the allowed %d files", opts.MaxFiles)
+		}
+
+		filename := strings.TrimPrefix(zipFile.Name, "./")
+
+		filename, ok := stripPathComponents(filename, opts.StripComponents)
+		if !ok {
+			continue // Not enough components to strip, skip entry
+		}
+		if filename == "" || filename == "." {
+			continue
+		}
+
+		targetPath, err := safeJoin(targetDir, filename)
+		if err != nil {
+			return fmt.Errorf("%s: %v", zipFile.Name, err)
+		}
+
+		if zipFile.FileInfo().IsDir() {
+			if err := os.MkdirAll(targetPath, zipFile.Mode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(targetPath), zipFile.Mode()); err != nil {
+			return err
+		}
+
+		reader, err := zipFile.Open()
+		if err != nil {
+			return err
+		}
+
+		outFile, err := os.Create(targetPath)
+		if err != nil {
+			reader.Close()
+			return err
+		}
+
+		// Cap the bytes actually written rather than trusting
+		// UncompressedSize64: that field is attacker-controlled metadata,
+		// and unlike archive/tar.Reader, archive/zip's flate decompressor
+		// does not itself refuse to yield more than the entry declares.
+		var copyErr error
+		var written int64
+		if opts.MaxSize > 0 {
+			written, copyErr = io.Copy(outFile, io.LimitReader(reader, opts.MaxSize-maxSizeBytesDone+1))
+			maxSizeBytesDone += written
+		} else {
+			written, copyErr = io.Copy(outFile, reader)
+		}
+		reader.Close()
+		outFile.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+		if opts.MaxSize > 0 && maxSizeBytesDone > opts.MaxSize {
+			return fmt.Errorf("archive exceeds maximum allowed size of %d bytes", opts.MaxSize)
+		}
+
+		if progress != nil {
+			progress(ProgressEvent{
+				CurrentPath: zipFile.Name,
+				BytesDone:   atomic.LoadInt64(bytesRead),
+				BytesTotal:  bytesTotal,
+				FilesDone:   filesDone,
+			})
+		}
+	}
+
+	return nil
+}
+
+// PackAs creates an archive from the source directory (sourceDir) in the
+// given format and saves it to targetArchive. level is the compression
+// level (0-9); its meaning is format-specific and it is ignored by formats
+// that don't support a numeric level (currently FormatTarXz).
+func PackAs(sourceDir, targetArchive string, format PackFormat, level int) error {
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return fmt.Errorf("source directory does not exist: %v", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("source is not a directory")
+	}
+
+	archiveFile, err := os.Create(targetArchive)
+	if err != nil {
+		return fmt.Errorf("could not create archive file: %v", err)
+	}
+	defer archiveFile.Close()
+
+	switch format {
+	case FormatTarGz:
+		return packDirToWriter(sourceDir, archiveFile, "", level, PackOptions{})
+
+	case FormatTarZst:
+		zstdWriter, err := zstd.NewWriter(archiveFile, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			return fmt.Errorf("could not create zstd writer: %v", err)
+		}
+		defer zstdWriter.Close()
+
+		tarWriter := tar.NewWriter(zstdWriter)
+		defer tarWriter.Close()
+
+		return packDirToTarWriter(sourceDir, tarWriter, "")
+
+	case FormatTarXz:
+		xzWriter, err := xz.NewWriter(archiveFile)
+		if err != nil {
+			return fmt.Errorf("could not create xz writer: %v", err)
+		}
+		defer xzWriter.Close()
+
+		tarWriter := tar.NewWriter(xzWriter)
+		defer tarWriter.Close()
+
+		return packDirToTarWriter(sourceDir, tarWriter, "")
+
+	case FormatZip:
+		return packDirToZip(sourceDir, archiveFile, level)
+
+	default:
+		return fmt.Errorf("unsupported pack format: %v", format)
+	}
+}
+
+// packDirToZip walks sourceDir and writes its contents as a zip archive to
+// w. A level of 0 stores entries uncompressed; any other level deflates
+// them, since archive/zip does not expose Deflate's finer-grained levels.
+func packDirToZip(sourceDir string, w io.Writer, level int) error {
+	zipWriter := zip.NewWriter(w)
+	defer zipWriter.Close()
+
+	method := zip.Deflate
+	if level == 0 {
+		method = zip.Store
+	}
+
+	return filepath.Walk(sourceDir, func(filePath string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(sourceDir, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = strings.TrimPrefix(relPath, "./")
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		header, err := zip.FileInfoHeader(fileInfo)
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+		header.Method = method
+
+		if fileInfo.IsDir() {
+			header.Name += "/"
+			_, err := zipWriter.CreateHeader(header)
+			return err
+		}
+
+		// zip.FileInfoHeader doesn't capture a symlink's target, and
+		// unpackZipWithOptions doesn't restore symlinks on extraction
+		// either, so packing one as-is would silently turn it into an
+		// empty regular file. Reject it instead of losing data quietly.
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("PackAs: zip format does not support symlinks: %s", relPath)
+		}
+
+		entryWriter, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if !fileInfo.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(entryWriter, file)
+		return err
+	})
+}
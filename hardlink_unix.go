@@ -0,0 +1,26 @@
+//go:build !windows
+
+package tgz
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeID identifies a file by the (device, inode) pair its platform
+// reports, used by Writer.AddFile to detect hardlinks.
+type inodeID struct {
+	dev uint64
+	ino uint64
+}
+
+// inodeKey reports fi's (device, inode) pair, if the platform's
+// os.FileInfo.Sys() exposes one.
+func inodeKey(fi os.FileInfo) (inodeID, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inodeID{}, false
+	}
+
+	return inodeID{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
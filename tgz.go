@@ -1,17 +1,23 @@
 // Package tgz provides functions to create and extract .tar.gz archives.
 // Supports custom compression levels, file path prefixes, and relative paths.
 // Compatible with cross-platform systems including Windows path structures.
+// Unpack also autodetects and reads zip, tar.bz2, tar.xz, and tar.zst
+// archives, and PackAs can produce tar.zst, tar.xz, and zip in addition to
+// tar.gz.
 
 package tgz
 
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Pack creates a .tar.gz archive from the source directory (sourceDir)
@@ -20,6 +26,18 @@ func Pack(sourceDir, targetArchive string) error {
 	return PackWithPrefix(sourceDir, targetArchive, "", -1)
 }
 
+// PackDeterministic creates a byte-identical-across-runs .tar.gz archive
+// from sourceDir, saved to targetArchive, with every entry's timestamp
+// clamped to sourceDateEpoch (a Unix timestamp, see
+// https://reproducible-builds.org/specs/source-date-epoch/). See
+// PackOptions.Deterministic for the full set of normalized fields.
+func PackDeterministic(sourceDir, targetArchive string, sourceDateEpoch int64) error {
+	return PackWithPrefixOptions(sourceDir, targetArchive, "", -1, PackOptions{
+		Deterministic:   true,
+		SourceDateEpoch: sourceDateEpoch,
+	})
+}
+
 // PackWitLevel creates a .tar.gz archive from the source directory (sourceDir)
 // and saves it to the specified targetArchive path, with a specified compression
 // level (0-9). Refer to https://pkg.go.dev/compress/flate#pkg-constants for level options.
@@ -31,6 +49,13 @@ func PackWitLevel(sourceDir, targetArchive string, level int) error {
 // adds a custom prefix to the paths within the archive (such as './' or filepath.Abs(sourceDir)),
 // and saves it to targetArchive with the specified gzip compression level (0-9).
 func PackWithPrefix(sourceDir, targetArchive, prefix string, level int) error {
+	return PackWithPrefixOptions(sourceDir, targetArchive, prefix, level, PackOptions{})
+}
+
+// PackWithPrefixOptions creates a .tar.gz archive exactly like PackWithPrefix,
+// additionally applying the given PackOptions to filter which entries of
+// sourceDir are archived.
+func PackWithPrefixOptions(sourceDir, targetArchive, prefix string, level int, opts PackOptions) error {
 	// Check if the source directory exists
 	info, err := os.Stat(sourceDir)
 	if err != nil {
@@ -48,18 +73,261 @@ func PackWithPrefix(sourceDir, targetArchive, prefix string, level int) error {
 	}
 	defer tarFile.Close()
 
-	// Initialize gzip and tar writers
-	gzipWriter, err := gzip.NewWriterLevel(tarFile, level)
+	return packDirToWriter(sourceDir, tarFile, prefix, level, opts)
+}
+
+// Option configures a streaming pack operation performed by PackToWriter.
+type Option func(*packConfig)
+
+// packConfig holds the settings that PackWithPrefixOptions's positional
+// arguments cover for callers that instead use PackToWriter's functional
+// options.
+type packConfig struct {
+	prefix string
+	level  int
+	opts   PackOptions
+}
+
+// WithPrefix sets the archive path prefix, equivalent to the prefix argument
+// of PackWithPrefix.
+func WithPrefix(prefix string) Option {
+	return func(c *packConfig) { c.prefix = prefix }
+}
+
+// WithLevel sets the gzip compression level (0-9), equivalent to the level
+// argument of PackWitLevel. Refer to
+// https://pkg.go.dev/compress/flate#pkg-constants for level options.
+func WithLevel(level int) Option {
+	return func(c *packConfig) { c.level = level }
+}
+
+// WithInclude restricts the archive to entries whose path relative to
+// sourceDir matches at least one of the given patterns, equivalent to
+// PackOptions.Include.
+func WithInclude(patterns ...string) Option {
+	return func(c *packConfig) { c.opts.Include = append(c.opts.Include, patterns...) }
+}
+
+// WithExclude skips entries whose relative path matches any of the given
+// patterns, equivalent to PackOptions.Exclude.
+func WithExclude(patterns ...string) Option {
+	return func(c *packConfig) { c.opts.Exclude = append(c.opts.Exclude, patterns...) }
+}
+
+// WithDeterministic enables reproducible output clamped to sourceDateEpoch,
+// equivalent to PackOptions.Deterministic and PackOptions.SourceDateEpoch.
+func WithDeterministic(sourceDateEpoch int64) Option {
+	return func(c *packConfig) {
+		c.opts.Deterministic = true
+		c.opts.SourceDateEpoch = sourceDateEpoch
+	}
+}
+
+// WithFollowSymlinks makes the walk follow directory symlinks instead of
+// archiving them as symlink entries, equivalent to PackOptions.FollowSymlinks.
+func WithFollowSymlinks(follow bool) Option {
+	return func(c *packConfig) { c.opts.FollowSymlinks = follow }
+}
+
+// WithIgnoreFile names a gitignore-style file read from each directory,
+// equivalent to PackOptions.IgnoreFile.
+func WithIgnoreFile(name string) Option {
+	return func(c *packConfig) { c.opts.IgnoreFile = name }
+}
+
+// PackToWriter creates a .tar.gz archive from the source directory (sourceDir)
+// and streams it to w instead of a file on disk, so callers can pipe an
+// archive directly to an HTTP response, an S3 upload, or a subprocess's
+// stdin without a temp file.
+func PackToWriter(sourceDir string, w io.Writer, opts ...Option) error {
+	cfg := packConfig{level: -1}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return fmt.Errorf("source directory does not exist: %v", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("source is not a directory")
+	}
+
+	return packDirToWriter(sourceDir, w, cfg.prefix, cfg.level, cfg.opts)
+}
+
+// packDirToWriter walks sourceDir and streams a .tar.gz archive to w using
+// the given path prefix and gzip compression level, applying opts to decide
+// which entries are included.
+func packDirToWriter(sourceDir string, w io.Writer, prefix string, level int, opts PackOptions) error {
+	var writerOpts []WriterOption
+	if opts.Deterministic {
+		writerOpts = append(writerOpts, WriterWithDeterministic(opts.SourceDateEpoch))
+	}
+
+	archiveWriter, err := NewWriter(w, level, writerOpts...)
+	if err != nil {
+		return err
+	}
+	defer archiveWriter.Close()
+
+	return walkPackEntries(sourceDir, opts, func(archiveRelPath, filePath string, fileInfo os.FileInfo) error {
+		return addArchiveEntry(archiveWriter, prefix, archiveRelPath, filePath, fileInfo)
+	})
+}
+
+// walkPackEntries walks sourceDir, applying opts to decide which entries are
+// included, and calls visit with each accepted entry's archive-relative path,
+// its path on disk, and its os.FileInfo. Directories are visited too, so
+// visit can write directory headers; returning filepath.SkipDir from visit
+// behaves as it does for filepath.Walk. It underlies both packDirToWriter and
+// the totals pass PackContext uses to report ProgressEvent.BytesTotal and
+// ProgressEvent.FilesTotal.
+func walkPackEntries(sourceDir string, opts PackOptions, visit func(archiveRelPath, filePath string, fileInfo os.FileInfo) error) error {
+	ignoreCache := map[string][]string{}
+
+	// visitedDirs tracks the (device, inode) pairs of sourceDir and of every
+	// directory entered through a followed symlink, so a followed symlink
+	// that points back at one of them is caught as a cycle instead of
+	// recursing forever. Left empty on platforms where inodeKey can't report
+	// device/inode numbers.
+	visitedDirs := map[inodeID]bool{}
+	if rootInfo, err := os.Stat(sourceDir); err == nil {
+		if id, ok := inodeKey(rootInfo); ok {
+			visitedDirs[id] = true
+		}
+	}
+
+	// walkDir visits physicalRoot, naming entries as if physicalRoot were
+	// located at archiveBase in the archive. It is called recursively, on the
+	// resolved path, whenever a followed symlink points at a directory.
+	var walkDir func(physicalRoot, archiveBase string) error
+	walkDir = func(physicalRoot, archiveBase string) error {
+		return filepath.Walk(physicalRoot, func(filePath string, fileInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(physicalRoot, filePath)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(strings.TrimPrefix(rel, "./"))
+
+			var archiveRelPath string
+			switch {
+			case rel == ".":
+				archiveRelPath = archiveBase
+			case archiveBase == "":
+				archiveRelPath = rel
+			default:
+				archiveRelPath = archiveBase + "/" + rel
+			}
+
+			// The archive root itself has nothing to write.
+			if archiveRelPath == "" {
+				return nil
+			}
+
+			if isExcluded(filePath, archiveRelPath, opts, ignoreCache) {
+				if fileInfo.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if fileInfo.Mode()&os.ModeSymlink != 0 && opts.FollowSymlinks {
+				if resolvedInfo, statErr := os.Stat(filePath); statErr == nil {
+					if resolvedInfo.IsDir() {
+						if id, ok := inodeKey(resolvedInfo); ok {
+							if visitedDirs[id] {
+								return fmt.Errorf("symlink cycle detected: %s points at an already-visited directory", filePath)
+							}
+							visitedDirs[id] = true
+						}
+						// filepath.Walk does not descend into a root that is
+						// itself a symlink, so walk the resolved directory.
+						resolvedPath, err := filepath.EvalSymlinks(filePath)
+						if err != nil {
+							return err
+						}
+						return walkDir(resolvedPath, archiveRelPath)
+					}
+					fileInfo = resolvedInfo
+				}
+			}
+
+			if !fileInfo.IsDir() && len(opts.Include) > 0 && !matchesAny(archiveRelPath, opts.Include) {
+				return nil
+			}
+
+			return visit(archiveRelPath, filePath, fileInfo)
+		})
+	}
+
+	return walkDir(sourceDir, "")
+}
+
+// addArchiveEntry writes a single header (and, for regular files, its
+// content) to archiveWriter for the entry at filePath, naming it
+// archiveRelPath prefixed by prefix.
+func addArchiveEntry(archiveWriter *Writer, prefix, archiveRelPath, filePath string, fileInfo os.FileInfo) error {
+	name := filepath.ToSlash(applyPrefix(archiveRelPath, prefix))
+
+	if fileInfo.IsDir() {
+		return archiveWriter.AddDir(name)
+	}
+
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return err
+		}
+		return archiveWriter.AddSymlink(name, target)
+	}
+
+	entryWriter, err := archiveWriter.AddFile(name, fileInfo)
+	if err != nil {
+		return err
+	}
+
+	// Non-regular files, and hardlinks AddFile has already seen under
+	// another name (reported by a nil entryWriter), carry no content.
+	if entryWriter == nil || !fileInfo.Mode().IsRegular() {
+		return nil
+	}
+
+	// Open the file to read its content
+	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("could not create gzip writer: %v", err)
+		return err
 	}
-	defer gzipWriter.Close()
+	defer file.Close()
 
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+	// Copy file content to the archive
+	_, err = io.Copy(entryWriter, file)
+	return err
+}
+
+// applyPrefix prepends prefix to relPath the way PackWithPrefix historically
+// has: a prefix starting with "./" is prepended verbatim, anything else is
+// joined as a path segment.
+func applyPrefix(relPath, prefix string) string {
+	if prefix == "" {
+		return relPath
+	}
+	if strings.HasPrefix(prefix, "./") {
+		return prefix + relPath
+	}
+	return filepath.Join(prefix, relPath)
+}
 
-	// Archive each file from the source directory
-	err = filepath.Walk(sourceDir, func(filePath string, fileInfo os.FileInfo, err error) error {
+// packDirToTarWriter walks sourceDir and writes its contents as tar entries
+// to tw using the given archive path prefix, without managing compression;
+// callers are responsible for wrapping and closing the underlying writer.
+func packDirToTarWriter(sourceDir string, tw *tar.Writer, prefix string) error {
+	return filepath.Walk(sourceDir, func(filePath string, fileInfo os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -92,15 +360,29 @@ func PackWithPrefix(sourceDir, targetArchive, prefix string, level int) error {
 		// Convert all paths to use forward slashes
 		relPath = filepath.ToSlash(relPath)
 
-		// Obtain file header for the archive entry
-		header, err := tar.FileInfoHeader(fileInfo, fileInfo.Name())
+		if fileInfo.IsDir() {
+			return tw.WriteHeader(&tar.Header{
+				Name:     relPath,
+				Typeflag: tar.TypeDir,
+				Mode:     int64(os.ModePerm),
+			})
+		}
+
+		var linkTarget string
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			linkTarget, err = os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(fileInfo, linkTarget)
 		if err != nil {
 			return err
 		}
 		header.Name = relPath
 
-		// Write file header to the archive
-		if err := tarWriter.WriteHeader(header); err != nil {
+		if err := tw.WriteHeader(header); err != nil {
 			return err
 		}
 
@@ -117,22 +399,220 @@ func PackWithPrefix(sourceDir, targetArchive, prefix string, level int) error {
 		defer file.Close()
 
 		// Copy file content to the archive
-		if _, err := io.Copy(tarWriter, file); err != nil {
+		if _, err := io.Copy(tw, file); err != nil {
 			return err
 		}
 
 		return nil
 	})
+}
+
+// Writer incrementally builds a .tar.gz archive written to an underlying
+// io.Writer. Callers add entries with AddDir and AddFile, then call Close to
+// flush and close the gzip and tar streams.
+type Writer struct {
+	gzipWriter *gzip.Writer
+	tarWriter  *tar.Writer
+
+	deterministic   bool
+	sourceDateEpoch int64
+
+	// seenInodes maps a regular file's (device, inode) pair to the first
+	// archive name it was written under, so later files sharing that
+	// inode are written as tar.TypeLink hardlinks instead of duplicating
+	// their content. Populated lazily by AddFile, and only on platforms
+	// where inodeKey can report device/inode numbers.
+	seenInodes map[inodeID]string
+}
+
+// WriterOption configures a Writer created by NewWriter.
+type WriterOption func(*Writer)
+
+// WriterWithDeterministic makes the Writer normalize every entry's metadata
+// for reproducible output: ModTime is clamped to sourceDateEpoch,
+// AccessTime/ChangeTime are zeroed, Uid/Gid/Uname/Gname are normalized to
+// 0/"root", and Mode is masked to a canonical 0644 (0755 for directories and
+// executables). See https://reproducible-builds.org/specs/source-date-epoch/.
+func WriterWithDeterministic(sourceDateEpoch int64) WriterOption {
+	return func(w *Writer) {
+		w.deterministic = true
+		w.sourceDateEpoch = sourceDateEpoch
+	}
+}
+
+// NewWriter creates a Writer that streams a .tar.gz archive to w using the
+// given gzip compression level (0-9). Refer to
+// https://pkg.go.dev/compress/flate#pkg-constants for level options.
+func NewWriter(w io.Writer, level int, opts ...WriterOption) (*Writer, error) {
+	gzipWriter, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, fmt.Errorf("could not create gzip writer: %v", err)
+	}
+
+	writer := &Writer{
+		gzipWriter: gzipWriter,
+		tarWriter:  tar.NewWriter(gzipWriter),
+	}
+	for _, opt := range opts {
+		opt(writer)
+	}
 
+	return writer, nil
+}
+
+// AddDir writes a directory entry named name to the archive.
+func (w *Writer) AddDir(name string) error {
+	header := &tar.Header{
+		Name:     filepath.ToSlash(name),
+		Typeflag: tar.TypeDir,
+		Mode:     int64(os.ModePerm),
+	}
+
+	if w.deterministic {
+		normalizeHeader(header, w.sourceDateEpoch)
+	}
+
+	return w.tarWriter.WriteHeader(header)
+}
+
+// AddSymlink writes a symlink entry named name, pointing at target, to the
+// archive.
+func (w *Writer) AddSymlink(name, target string) error {
+	header := &tar.Header{
+		Name:     filepath.ToSlash(name),
+		Linkname: target,
+		Typeflag: tar.TypeSymlink,
+		Mode:     int64(os.ModePerm),
+	}
+
+	if w.deterministic {
+		normalizeHeader(header, w.sourceDateEpoch)
+	}
+
+	return w.tarWriter.WriteHeader(header)
+}
+
+// AddFile writes a header named name describing fi to the archive, and
+// returns the io.Writer the caller must use to write the entry's content.
+// Callers must write exactly fi.Size() bytes for regular files, and nothing
+// for non-regular ones.
+//
+// If fi is a regular file sharing its (device, inode) pair with a file
+// already added under a different name, AddFile instead writes a
+// tar.TypeLink entry pointing at that earlier name and returns a nil
+// io.Writer, since the hardlink carries no content of its own.
+func (w *Writer) AddFile(name string, fi os.FileInfo) (io.Writer, error) {
+	if fi.Mode().IsRegular() {
+		if id, ok := inodeKey(fi); ok {
+			if w.seenInodes == nil {
+				w.seenInodes = map[inodeID]string{}
+			}
+			if firstName, seen := w.seenInodes[id]; seen {
+				header := &tar.Header{
+					Name:     filepath.ToSlash(name),
+					Linkname: firstName,
+					Typeflag: tar.TypeLink,
+				}
+				if w.deterministic {
+					normalizeHeader(header, w.sourceDateEpoch)
+				}
+				return nil, w.tarWriter.WriteHeader(header)
+			}
+			w.seenInodes[id] = filepath.ToSlash(name)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(fi, fi.Name())
 	if err != nil {
+		return nil, err
+	}
+	header.Name = filepath.ToSlash(name)
+
+	if w.deterministic {
+		normalizeHeader(header, w.sourceDateEpoch)
+	}
+
+	if err := w.tarWriter.WriteHeader(header); err != nil {
+		return nil, err
+	}
+
+	return w.tarWriter, nil
+}
+
+// normalizeHeader clears header fields that would otherwise make an archive
+// depend on when and where it was built, so that packing the same input
+// tree twice produces byte-identical output.
+func normalizeHeader(header *tar.Header, sourceDateEpoch int64) {
+	modTime := time.Unix(sourceDateEpoch, 0).UTC()
+	header.ModTime = modTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = "root"
+	header.Gname = "root"
+
+	switch {
+	case header.Typeflag == tar.TypeDir, header.Mode&0o111 != 0:
+		header.Mode = 0o755
+	default:
+		header.Mode = 0o644
+	}
+}
+
+// Close flushes and closes the tar and gzip streams. It does not close the
+// underlying io.Writer.
+func (w *Writer) Close() error {
+	if err := w.tarWriter.Close(); err != nil {
 		return err
 	}
 
-	return nil
+	return w.gzipWriter.Close()
+}
+
+// UnpackOptions controls how UnpackWithOptions extracts an archive.
+type UnpackOptions struct {
+	// AllowSymlinks permits tar.TypeSymlink and tar.TypeLink entries to
+	// be materialized on disk with os.Symlink/os.Link. When false (the
+	// default), such entries are skipped, matching the historical
+	// behavior of Unpack.
+	AllowSymlinks bool
+
+	// StripComponents removes this many leading path elements from
+	// every entry name before it is extracted, similar to tar's
+	// --strip-components. Entries with no more than this many components
+	// (nothing would remain after stripping) are skipped.
+	StripComponents int
+
+	// MaxSize limits the total number of bytes written to disk while
+	// extracting. Zero means unlimited.
+	MaxSize int64
+
+	// MaxFiles limits the number of entries read from the archive.
+	// Zero means unlimited.
+	MaxFiles int
+
+	// PreserveOwnership restores each entry's Uid/Gid and file mode from
+	// the archive header after it is created, via os.Lchown/os.Chown and
+	// os.Chmod. It is off by default since unprivileged callers usually
+	// can't chown to an arbitrary uid/gid; when that call fails, Unpack
+	// reports the error rather than silently keeping the extracting
+	// process's own ownership.
+	PreserveOwnership bool
 }
 
 // Unpack extracts a .tar.gz archive (sourceArchive) into the target directory (targetDir).
 func Unpack(sourceArchive, targetDir string) error {
+	return UnpackWithOptions(sourceArchive, targetDir, UnpackOptions{})
+}
+
+// UnpackWithOptions extracts a .tar.gz archive (sourceArchive) into the target
+// directory (targetDir), honoring the given UnpackOptions. Every entry is
+// checked to ensure its resolved path (and, for symlinks and hardlinks, its
+// resolved link target) stays within targetDir, guarding against zip-slip
+// and symlink-escape attacks from malicious archives.
+func UnpackWithOptions(sourceArchive, targetDir string, opts UnpackOptions) error {
 	// Open archive file for reading
 	file, err := os.Open(sourceArchive)
 	if err != nil {
@@ -140,17 +620,35 @@ func Unpack(sourceArchive, targetDir string) error {
 	}
 	defer file.Close()
 
-	// Initialize gzip and tar readers
-	gzipReader, err := gzip.NewReader(file)
-	if err != nil {
-		return fmt.Errorf("could not create gzip reader: %v", err)
-	}
-	defer gzipReader.Close()
+	return unpackAnyWithOptions(context.Background(), file, targetDir, opts, nil, 0)
+}
 
-	tarReader := tar.NewReader(gzipReader)
+// UnpackFromReader extracts an archive stream read from r into the target
+// directory (targetDir), so callers can unpack an archive received over an
+// HTTP response body, an S3 download, or a subprocess's stdout without a
+// temp file. The archive's compression/container format is autodetected, as
+// in Unpack, and the same zip-slip and symlink-escape protections apply.
+func UnpackFromReader(r io.Reader, targetDir string) error {
+	return unpackAnyWithOptions(context.Background(), r, targetDir, UnpackOptions{}, nil, 0)
+}
+
+// unpackTarEntries extracts every entry read from tarReader into targetDir,
+// honoring opts. It is the common extraction loop shared by every tar-based
+// archive format (plain, gzip, bzip2, xz, and zstd); unpackAnyWithOptions in
+// format.go picks the decompressor and builds tarReader. ctx is checked
+// between entries, so a cancelled context stops extraction at the next
+// header; progress, if non-nil, is reported after each entry, reading
+// bytesRead for ProgressEvent.BytesDone (see progressReader in context.go).
+func unpackTarEntries(ctx context.Context, tarReader *tar.Reader, targetDir string, opts UnpackOptions, progress Progress, bytesRead *int64, bytesTotal int64) error {
+	var filesDone int
+	var maxSizeBytesDone int64
 
 	// Iterate over each file in the archive
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break // End of archive
@@ -159,12 +657,30 @@ func Unpack(sourceArchive, targetDir string) error {
 			return err
 		}
 
+		filesDone++
+		if opts.MaxFiles > 0 && filesDone > opts.MaxFiles {
+			return fmt.Errorf("archive contains more than the allowed %d files", opts.MaxFiles)
+		}
+
 		// Determine the file path for extraction
-		filename := header.Name
+		filename := strings.TrimPrefix(header.Name, "./")
 
-		// Remove any leading "./" from path if present
-		filename = strings.TrimPrefix(filename, "./")
-		targetPath := filepath.Join(targetDir, filename)
+		filename, ok := stripPathComponents(filename, opts.StripComponents)
+		if !ok {
+			continue // Not enough components to strip, skip entry
+		}
+		if filename == "" || filename == "." {
+			continue
+		}
+
+		// secureJoin, not safeJoin: an earlier entry in this same archive may
+		// have planted a symlink under one of filename's ancestor
+		// components, so the real, on-disk location this entry resolves to
+		// must be checked, not just its nominal header path.
+		targetPath, err := secureJoin(targetDir, filename)
+		if err != nil {
+			return fmt.Errorf("%s: %v", header.Name, err)
+		}
 
 		// Handle extraction based on file type
 		switch header.Typeflag {
@@ -173,8 +689,20 @@ func Unpack(sourceArchive, targetDir string) error {
 			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
 				return err
 			}
+			if opts.PreserveOwnership {
+				if err := restoreOwnership(targetPath, header); err != nil {
+					return err
+				}
+			}
 
 		case tar.TypeReg:
+			if opts.MaxSize > 0 {
+				maxSizeBytesDone += header.Size
+				if maxSizeBytesDone > opts.MaxSize {
+					return fmt.Errorf("archive exceeds maximum allowed size of %d bytes", opts.MaxSize)
+				}
+			}
+
 			// Create all necessary directories
 			if err := os.MkdirAll(filepath.Dir(targetPath), os.FileMode(header.Mode)); err != nil {
 				return err
@@ -190,11 +718,221 @@ func Unpack(sourceArchive, targetDir string) error {
 				return err
 			}
 			outFile.Close()
+			if opts.PreserveOwnership {
+				if err := restoreOwnership(targetPath, header); err != nil {
+					return err
+				}
+			}
+
+		case tar.TypeSymlink, tar.TypeLink:
+			if !opts.AllowSymlinks {
+				continue // Skip links unless explicitly allowed
+			}
+
+			if err := os.MkdirAll(filepath.Dir(targetPath), os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+			// os.Symlink/os.Link fail if the target already exists
+			os.Remove(targetPath)
+
+			if header.Typeflag == tar.TypeSymlink {
+				// An absolute Linkname would be written to disk verbatim by
+				// os.Symlink below, but filepath.Join silently drops its
+				// leading "/" when relativizing it against targetPath's
+				// directory, so the secureJoin check right after this would
+				// validate a different, always-contained path. Reject it
+				// explicitly instead of trusting that join.
+				if filepath.IsAbs(header.Linkname) {
+					return fmt.Errorf("symlink %s -> %s: illegal absolute link target", header.Name, header.Linkname)
+				}
+
+				// header.Linkname is resolved relative to targetPath's real
+				// (already symlink-resolved) directory once the link is
+				// followed on disk, not its nominal header path.
+				resolvedTarget := filepath.Join(filepath.Dir(targetPath), header.Linkname)
+				if err := requireWithinRoot(targetDir, resolvedTarget); err != nil {
+					return fmt.Errorf("symlink %s -> %s: %v", header.Name, header.Linkname, err)
+				}
+
+				if err := os.Symlink(header.Linkname, targetPath); err != nil {
+					return err
+				}
+			} else {
+				// header.Linkname names another entry in the archive by its
+				// original path, so it must go through the same StripComponents
+				// transform as filename above before being resolved on disk.
+				linkname := strings.TrimPrefix(header.Linkname, "./")
+				linkname, ok := stripPathComponents(linkname, opts.StripComponents)
+				if !ok {
+					return fmt.Errorf("hardlink %s -> %s: not enough path components to strip", header.Name, header.Linkname)
+				}
+
+				oldPath, err := secureJoin(targetDir, linkname)
+				if err != nil {
+					return fmt.Errorf("hardlink %s -> %s: %v", header.Name, header.Linkname, err)
+				}
+
+				if err := os.Link(oldPath, targetPath); err != nil {
+					return err
+				}
+			}
+			if opts.PreserveOwnership && header.Typeflag == tar.TypeSymlink {
+				if err := restoreOwnership(targetPath, header); err != nil {
+					return err
+				}
+			}
+
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if err := os.MkdirAll(filepath.Dir(targetPath), os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+			os.Remove(targetPath)
+
+			if err := createDevice(targetPath, header); err != nil {
+				return fmt.Errorf("%s: %v", header.Name, err)
+			}
+			if opts.PreserveOwnership {
+				if err := restoreOwnership(targetPath, header); err != nil {
+					return err
+				}
+			}
 
 		default:
 			// Skip other file types
 		}
+
+		if progress != nil {
+			progress(ProgressEvent{
+				CurrentPath: header.Name,
+				BytesDone:   atomic.LoadInt64(bytesRead),
+				BytesTotal:  bytesTotal,
+				FilesDone:   filesDone,
+			})
+		}
+	}
+
+	return nil
+}
+
+// stripPathComponents removes the first n slash-separated components from
+// name. It reports false if name has n or fewer components, meaning the
+// entry should be skipped entirely.
+func stripPathComponents(name string, n int) (string, bool) {
+	if n <= 0 {
+		return name, true
+	}
+
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	if len(parts) <= n {
+		return "", false
+	}
+
+	return filepath.Join(parts[n:]...), true
+}
+
+// restoreOwnership applies header's Uid/Gid and mode to the file just
+// created at targetPath. Symlinks are chowned without following them, since
+// following a symlink target here could point outside targetDir.
+func restoreOwnership(targetPath string, header *tar.Header) error {
+	if header.Typeflag == tar.TypeSymlink {
+		return os.Lchown(targetPath, header.Uid, header.Gid)
+	}
+
+	if err := os.Chown(targetPath, header.Uid, header.Gid); err != nil {
+		return err
 	}
 
+	return os.Chmod(targetPath, os.FileMode(header.Mode))
+}
+
+// safeJoin joins root and name, then verifies the resulting path does not
+// escape root (e.g. via a "../" entry name), returning an error if it does.
+func safeJoin(root, name string) (string, error) {
+	target := filepath.Join(root, name)
+	if err := requireWithinRoot(root, target); err != nil {
+		return "", fmt.Errorf("illegal path %q: %v", name, err)
+	}
+	return target, nil
+}
+
+// requireWithinRoot returns an error unless path (already absolute, or at
+// least joined against the same root) is root itself or a descendant of it.
+func requireWithinRoot(root, path string) error {
+	cleanRoot := filepath.Clean(root)
+	if path != cleanRoot && !strings.HasPrefix(path, cleanRoot+string(os.PathSeparator)) {
+		return fmt.Errorf("escapes target directory")
+	}
 	return nil
 }
+
+// secureJoin resolves name against root the way extracting it to disk would,
+// rather than safeJoin's purely lexical join: each path component is
+// checked against root in turn, and a component that already exists on disk
+// as a symlink (planted by an earlier entry in the same archive) has its
+// target resolved — recursively, and itself constrained to root — before the
+// next component is appended. A chain of symlink entries can each
+// individually pass safeJoin's lexical check on their own header path while
+// collectively redirecting a later, syntactically clean entry outside root;
+// secureJoin catches that by following the filesystem state as it actually
+// stands after each prior entry, the same class of check docker/moby's
+// FollowSymlinkInScope performs. For a name with no pre-existing symlinks in
+// its path, it resolves the same target as safeJoin.
+func secureJoin(root, name string) (string, error) {
+	root = filepath.Clean(root)
+	return secureJoinWalk(root, root, name, new(int))
+}
+
+// secureJoinWalk implements secureJoin. root is the fixed escape boundary
+// for the whole resolution; base is where name's components are joined from
+// (root for a top-level call, or a resolved symlink's directory when
+// recursing to follow that symlink's relative target). linksWalked is
+// threaded across recursive calls (one per resolved symlink component) so a
+// symlink cycle planted by the archive is rejected instead of recursing
+// forever.
+func secureJoinWalk(root, base, name string, linksWalked *int) (string, error) {
+	current := base
+
+	remaining := filepath.ToSlash(name)
+	for remaining != "" {
+		var component string
+		if idx := strings.IndexByte(remaining, '/'); idx >= 0 {
+			component, remaining = remaining[:idx], remaining[idx+1:]
+		} else {
+			component, remaining = remaining, ""
+		}
+		if component == "" || component == "." {
+			continue
+		}
+
+		next := current
+		if component == ".." {
+			next = filepath.Dir(current)
+		} else {
+			next = filepath.Join(current, component)
+		}
+		if err := requireWithinRoot(root, next); err != nil {
+			return "", fmt.Errorf("illegal path %q: %v", name, err)
+		}
+		current = next
+
+		target, err := os.Readlink(current)
+		if err != nil {
+			continue // Not a symlink, or doesn't exist on disk yet.
+		}
+
+		*linksWalked++
+		if *linksWalked > 255 {
+			return "", fmt.Errorf("illegal path %q: too many levels of symbolic links", name)
+		}
+		if filepath.IsAbs(target) {
+			return "", fmt.Errorf("illegal path %q: component %q resolves through an absolute symlink", name, component)
+		}
+
+		current, err = secureJoinWalk(root, filepath.Dir(current), target, linksWalked)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return current, nil
+}
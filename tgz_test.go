@@ -1,6 +1,11 @@
 package tgz
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -55,6 +60,577 @@ func TestPackAndUnpackDir(t *testing.T) {
 	}
 }
 
+func TestUnpackRejectsPathTraversal(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "evil.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "../../etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 0},
+	}, nil); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	if err := Unpack(srcTar, dstDir); err == nil {
+		t.Fatal("expected Unpack to reject a path-traversal entry, got nil error")
+	}
+}
+
+func TestUnpackRejectsSymlinkEscape(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "evil.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../../etc", Mode: 0777},
+	}, nil); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{AllowSymlinks: true})
+	if err == nil {
+		t.Fatal("expected UnpackWithOptions to reject an escaping symlink target, got nil error")
+	}
+}
+
+func TestUnpackRejectsAbsoluteSymlinkEscape(t *testing.T) {
+	outsideDir := t.TempDir()
+
+	srcTar := filepath.Join(t.TempDir(), "evil.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "evil_link", Typeflag: tar.TypeSymlink, Linkname: outsideDir, Mode: 0777},
+		{Name: "evil_link/pwned.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, [][]byte{nil, []byte("pwned")}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{AllowSymlinks: true})
+	if err == nil {
+		t.Fatal("expected UnpackWithOptions to reject an absolute escaping symlink target, got nil error")
+	}
+
+	if _, statErr := os.Stat(filepath.Join(outsideDir, "pwned.txt")); statErr == nil {
+		t.Fatal("UnpackWithOptions wrote a file outside targetDir through the absolute symlink")
+	}
+}
+
+func TestUnpackRejectsChainedSymlinkEscape(t *testing.T) {
+	// link1 -> ".." and link2 -> "../.." each individually resolve within
+	// targetDir when checked against their own nominal (lexical) tar path,
+	// but once link1 is actually written to disk, link2's real parent
+	// directory is link1's *resolved* target (d1), not its nominal one
+	// (d1/d2/link1) — so link2's own target genuinely escapes targetDir,
+	// which only a check against the real, already-resolved filesystem
+	// state (not a lexical join of the header path) can catch.
+	srcTar := filepath.Join(t.TempDir(), "evil.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "d1", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "d1/d2", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "d1/d2/link1", Typeflag: tar.TypeSymlink, Linkname: "..", Mode: 0777},
+		{Name: "d1/d2/link1/link2", Typeflag: tar.TypeSymlink, Linkname: "../..", Mode: 0777},
+		{Name: "d1/d2/link1/link2/pwned.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, [][]byte{nil, nil, nil, nil, []byte("pwned")}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{AllowSymlinks: true})
+	if err == nil {
+		t.Fatal("expected UnpackWithOptions to reject a chained symlink escape, got nil error")
+	}
+
+	if _, statErr := os.Stat(filepath.Join(filepath.Dir(dstDir), "pwned.txt")); statErr == nil {
+		t.Fatal("UnpackWithOptions wrote a file outside targetDir through the chained symlink")
+	}
+}
+
+func TestUnpackRejectsOversizeArchive(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "big.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "big.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, [][]byte{bytes.Repeat([]byte("x"), 100)}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{MaxSize: 10})
+	if err == nil {
+		t.Fatal("expected UnpackWithOptions to reject an archive exceeding MaxSize, got nil error")
+	}
+}
+
+func TestUnpackRejectsTooManyFiles(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "many.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, [][]byte{[]byte("a"), []byte("b")}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{MaxFiles: 1})
+	if err == nil {
+		t.Fatal("expected UnpackWithOptions to reject an archive exceeding MaxFiles, got nil error")
+	}
+}
+
+func TestUnpackStripComponents(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "nested.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "a/b/c.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, [][]byte{[]byte("content")}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	if err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{StripComponents: 2}); err != nil {
+		t.Fatalf("UnpackWithOptions: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "c.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile(c.txt): %v", err)
+	}
+	if string(got) != "content" {
+		t.Fatalf("c.txt content = %q, want %q", got, "content")
+	}
+
+	if _, err := os.Stat(filepath.Join(dstDir, "a")); err == nil {
+		t.Fatal("StripComponents left the stripped \"a\" directory behind")
+	}
+}
+
+func TestUnpackStripComponentsAppliesToHardlinkTarget(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "hardlink.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "a/b/real.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "a/b/hard.txt", Typeflag: tar.TypeLink, Linkname: "a/b/real.txt", Mode: 0644},
+	}, [][]byte{[]byte("content")}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{AllowSymlinks: true, StripComponents: 2})
+	if err != nil {
+		t.Fatalf("UnpackWithOptions: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "hard.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile(hard.txt): %v", err)
+	}
+	if string(got) != "content" {
+		t.Fatalf("hard.txt content = %q, want %q", got, "content")
+	}
+}
+
+func TestUnpackZipEnforcesMaxSizeDuringCopy(t *testing.T) {
+	// A zip entry that declares a small UncompressedSize64 but whose actual
+	// stored bytes are far larger, as crafted by an attacker trying to
+	// smuggle data past a header-only MaxSize check.
+	archive := filepath.Join(t.TempDir(), "forged.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+
+	zipWriter := zip.NewWriter(f)
+	entryWriter, err := zipWriter.CreateRaw(&zip.FileHeader{
+		Name:               "big.txt",
+		Method:             zip.Store,
+		UncompressedSize64: 1,
+	})
+	if err != nil {
+		t.Fatalf("CreateRaw: %v", err)
+	}
+	if _, err := entryWriter.Write(bytes.Repeat([]byte("x"), 100)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatalf("zipWriter.Close: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("f.Close: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	err = UnpackWithOptions(archive, dstDir, UnpackOptions{MaxSize: 10})
+	if err == nil {
+		t.Fatal("expected UnpackWithOptions to reject an entry whose actual bytes exceed MaxSize, got nil error")
+	}
+}
+
+func TestUnpackPreserveOwnership(t *testing.T) {
+	srcTar := filepath.Join(t.TempDir(), "owned.tar.gz")
+	if err := writeTarGz(srcTar, []tar.Header{
+		{Name: "owned.txt", Typeflag: tar.TypeReg, Mode: 0640, Uid: os.Getuid(), Gid: os.Getgid()},
+	}, [][]byte{[]byte("content")}); err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	if err := UnpackWithOptions(srcTar, dstDir, UnpackOptions{PreserveOwnership: true}); err != nil {
+		t.Fatalf("UnpackWithOptions: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dstDir, "owned.txt"))
+	if err != nil {
+		t.Fatalf("Stat(owned.txt): %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+}
+
+// writeTarGz builds a minimal .tar.gz archive at path from the given headers,
+// writing contents[i] (if present) as the body of headers[i].
+func writeTarGz(path string, headers []tar.Header, contents [][]byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	for i := range headers {
+		h := headers[i]
+		if i < len(contents) {
+			h.Size = int64(len(contents[i]))
+		}
+		if err := tw.WriteHeader(&h); err != nil {
+			return err
+		}
+		if i < len(contents) {
+			if _, err := tw.Write(contents[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestPackToWriterAndUnpackFromReader(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "hello.txt"), "hello, tgz")
+
+	var buf bytes.Buffer
+	if err := PackToWriter(srcDir, &buf, WithPrefix("asd/qwe/"), WithLevel(6)); err != nil {
+		t.Fatalf("Failed to pack to writer: %v", err)
+	}
+
+	dstDir := t.TempDir()
+	if err := UnpackFromReader(&buf, dstDir); err != nil {
+		t.Fatalf("Failed to unpack from reader: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "asd", "qwe", "hello.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile(hello.txt): %v", err)
+	}
+	if string(got) != "hello, tgz" {
+		t.Fatalf("hello.txt content = %q, want %q", got, "hello, tgz")
+	}
+}
+
+func TestPackAsAndUnpackAllFormats(t *testing.T) {
+	srcDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(srcDir, "hello.txt"), []byte("hello, tgz"), 0644); err != nil {
+		t.Fatalf("Failed to write fixture file: %v", err)
+	}
+
+	formats := map[string]PackFormat{
+		"x.tar.gz":  FormatTarGz,
+		"x.tar.zst": FormatTarZst,
+		"x.tar.xz":  FormatTarXz,
+		"x.zip":     FormatZip,
+	}
+
+	for name, format := range formats {
+		archive := filepath.Join(t.TempDir(), name)
+		if err := PackAs(srcDir, archive, format, -1); err != nil {
+			t.Fatalf("PackAs(%s): %v", name, err)
+		}
+
+		dstDir := t.TempDir()
+		if err := Unpack(archive, dstDir); err != nil {
+			t.Fatalf("Unpack(%s): %v", name, err)
+		}
+
+		got, err := os.ReadFile(filepath.Join(dstDir, "hello.txt"))
+		if err != nil {
+			t.Fatalf("Unpack(%s): missing extracted file: %v", name, err)
+		}
+		if string(got) != "hello, tgz" {
+			t.Fatalf("Unpack(%s): got content %q, want %q", name, got, "hello, tgz")
+		}
+	}
+}
+
+func TestPackAsPreservesSymlinkTarget(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "real.txt"), "hello, tgz")
+	if err := os.Symlink("real.txt", filepath.Join(srcDir, "sym.txt")); err != nil {
+		t.Fatalf("Failed to create symlink fixture: %v", err)
+	}
+
+	for name, format := range map[string]PackFormat{
+		"x.tar.zst": FormatTarZst,
+		"x.tar.xz":  FormatTarXz,
+	} {
+		archive := filepath.Join(t.TempDir(), name)
+		if err := PackAs(srcDir, archive, format, -1); err != nil {
+			t.Fatalf("PackAs(%s): %v", name, err)
+		}
+
+		dstDir := t.TempDir()
+		if err := UnpackWithOptions(archive, dstDir, UnpackOptions{AllowSymlinks: true}); err != nil {
+			t.Fatalf("UnpackWithOptions(%s): %v", name, err)
+		}
+
+		target, err := os.Readlink(filepath.Join(dstDir, "sym.txt"))
+		if err != nil {
+			t.Fatalf("Readlink(%s/sym.txt): %v", name, err)
+		}
+		if target != "real.txt" {
+			t.Fatalf("Readlink(%s/sym.txt) = %q, want %q", name, target, "real.txt")
+		}
+	}
+}
+
+func TestPackAsZipRejectsSymlink(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "real.txt"), "hello, tgz")
+	if err := os.Symlink("real.txt", filepath.Join(srcDir, "sym.txt")); err != nil {
+		t.Fatalf("Failed to create symlink fixture: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "x.zip")
+	err := PackAs(srcDir, archive, FormatZip, -1)
+	if err == nil {
+		t.Fatal("expected PackAs(FormatZip) to reject a source tree containing a symlink, got nil error")
+	}
+}
+
+func TestPackWithPrefixOptionsFilters(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "keep.txt"), "keep")
+	mustWriteFile(t, filepath.Join(srcDir, "skip.log"), "skip")
+	mustWriteFile(t, filepath.Join(srcDir, ".tgzignore"), "ignored.txt\n")
+	mustWriteFile(t, filepath.Join(srcDir, "ignored.txt"), "ignored")
+	if err := os.Mkdir(filepath.Join(srcDir, "vendor"), 0755); err != nil {
+		t.Fatalf("Failed to create vendor dir: %v", err)
+	}
+	mustWriteFile(t, filepath.Join(srcDir, "vendor", "dep.txt"), "dep")
+
+	archive := filepath.Join(t.TempDir(), "filtered.tar.gz")
+	err := PackWithPrefixOptions(srcDir, archive, "", -1, PackOptions{
+		Exclude:    []string{"vendor", "vendor/**"},
+		IgnoreFile: ".tgzignore",
+	})
+	if err != nil {
+		t.Fatalf("PackWithPrefixOptions: %v", err)
+	}
+
+	dstDir := filepath.Join(t.TempDir(), "out")
+	if err := Unpack(archive, dstDir); err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	assertExists(t, filepath.Join(dstDir, "keep.txt"), true)
+	assertExists(t, filepath.Join(dstDir, "skip.log"), true)
+	assertExists(t, filepath.Join(dstDir, "ignored.txt"), false)
+	assertExists(t, filepath.Join(dstDir, "vendor"), false)
+}
+
+func mustWriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func assertExists(t *testing.T, path string, want bool) {
+	t.Helper()
+	_, err := os.Stat(path)
+	got := err == nil
+	if got != want {
+		t.Fatalf("os.Stat(%s): exists=%v, want %v", path, got, want)
+	}
+}
+
+func TestPackWithPrefixOptionsRejectsSymlinkCycle(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "real.txt"), "content")
+	// A directory symlink pointing back at srcDir itself, the simplest case
+	// of a followed symlink introducing a cycle.
+	if err := os.Symlink(srcDir, filepath.Join(srcDir, "loop")); err != nil {
+		t.Fatalf("Failed to create symlink fixture: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "loop.tar.gz")
+	err := PackWithPrefixOptions(srcDir, archive, "", -1, PackOptions{FollowSymlinks: true})
+	if err == nil {
+		t.Fatal("expected PackWithPrefixOptions to reject a symlink cycle, got nil error")
+	}
+}
+
+func TestPackDeterministicIsByteIdentical(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "a.txt"), "a")
+	if err := os.Mkdir(filepath.Join(srcDir, "dir"), 0700); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	mustWriteFile(t, filepath.Join(srcDir, "dir", "b.txt"), "b")
+
+	archive1 := filepath.Join(t.TempDir(), "one.tar.gz")
+	archive2 := filepath.Join(t.TempDir(), "two.tar.gz")
+
+	if err := PackDeterministic(srcDir, archive1, 1700000000); err != nil {
+		t.Fatalf("PackDeterministic (1): %v", err)
+	}
+	// Change ownership-irrelevant, host-dependent metadata between runs to
+	// make sure it doesn't leak into the archive.
+	if err := os.Chmod(filepath.Join(srcDir, "a.txt"), 0600); err != nil {
+		t.Fatalf("Failed to chmod fixture: %v", err)
+	}
+	if err := PackDeterministic(srcDir, archive2, 1700000000); err != nil {
+		t.Fatalf("PackDeterministic (2): %v", err)
+	}
+
+	data1, err := os.ReadFile(archive1)
+	if err != nil {
+		t.Fatalf("Failed to read archive1: %v", err)
+	}
+	data2, err := os.ReadFile(archive2)
+	if err != nil {
+		t.Fatalf("Failed to read archive2: %v", err)
+	}
+
+	if !bytes.Equal(data1, data2) {
+		t.Fatal("PackDeterministic produced different bytes across runs with the same input tree")
+	}
+}
+
+func TestPackAndUnpackPreservesSymlinksAndHardlinks(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "real.txt"), "content")
+	if err := os.Symlink("real.txt", filepath.Join(srcDir, "sym.txt")); err != nil {
+		t.Fatalf("Failed to create symlink fixture: %v", err)
+	}
+	if err := os.Link(filepath.Join(srcDir, "real.txt"), filepath.Join(srcDir, "hard.txt")); err != nil {
+		t.Fatalf("Failed to create hardlink fixture: %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "links.tar.gz")
+	if err := PackWithPrefix(srcDir, archive, "", -1); err != nil {
+		t.Fatalf("PackWithPrefix: %v", err)
+	}
+
+	dstDir := filepath.Join(t.TempDir(), "out")
+	if err := UnpackWithOptions(archive, dstDir, UnpackOptions{AllowSymlinks: true}); err != nil {
+		t.Fatalf("UnpackWithOptions: %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(dstDir, "sym.txt"))
+	if err != nil {
+		t.Fatalf("Readlink(sym.txt): %v", err)
+	}
+	if target != "real.txt" {
+		t.Fatalf("Readlink(sym.txt) = %q, want %q", target, "real.txt")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dstDir, "hard.txt"))
+	if err != nil {
+		t.Fatalf("ReadFile(hard.txt): %v", err)
+	}
+	if string(got) != "content" {
+		t.Fatalf("hard.txt content = %q, want %q", got, "content")
+	}
+}
+
+func TestPackContextReportsProgressAndTotals(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "a.txt"), "hello")
+	mustWriteFile(t, filepath.Join(srcDir, "b.txt"), "world!")
+
+	archive := filepath.Join(t.TempDir(), "x.tar.gz")
+
+	var events []ProgressEvent
+	err := PackContext(context.Background(), srcDir, archive, "", -1, PackOptions{}, func(e ProgressEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("PackContext: %v", err)
+	}
+
+	if len(events) == 0 {
+		t.Fatalf("PackContext reported no progress events")
+	}
+
+	last := events[len(events)-1]
+	if last.FilesDone != last.FilesTotal {
+		t.Fatalf("final FilesDone = %d, want FilesTotal %d", last.FilesDone, last.FilesTotal)
+	}
+	if last.BytesDone != last.BytesTotal {
+		t.Fatalf("final BytesDone = %d, want BytesTotal %d", last.BytesDone, last.BytesTotal)
+	}
+	if last.BytesTotal != int64(len("hello")+len("world!")) {
+		t.Fatalf("BytesTotal = %d, want %d", last.BytesTotal, len("hello")+len("world!"))
+	}
+}
+
+func TestPackContextHonorsCancellation(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "a.txt"), "hello")
+	mustWriteFile(t, filepath.Join(srcDir, "b.txt"), "world!")
+
+	archive := filepath.Join(t.TempDir(), "x.tar.gz")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := PackContext(ctx, srcDir, archive, "", -1, PackOptions{}, nil)
+	if err == nil {
+		t.Fatalf("PackContext with a cancelled context succeeded, want an error")
+	}
+}
+
+func TestUnpackContextReportsProgress(t *testing.T) {
+	srcDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(srcDir, "a.txt"), "hello")
+
+	archive := filepath.Join(t.TempDir(), "x.tar.gz")
+	if err := PackWithPrefix(srcDir, archive, "", -1); err != nil {
+		t.Fatalf("PackWithPrefix: %v", err)
+	}
+
+	dstDir := t.TempDir()
+
+	var events []ProgressEvent
+	err := UnpackContext(context.Background(), archive, dstDir, UnpackOptions{}, func(e ProgressEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("UnpackContext: %v", err)
+	}
+
+	if len(events) == 0 {
+		t.Fatalf("UnpackContext reported no progress events")
+	}
+
+	last := events[len(events)-1]
+	if last.BytesTotal == 0 {
+		t.Fatalf("BytesTotal = 0, want the archive's on-disk size")
+	}
+	if last.BytesDone != last.BytesTotal {
+		t.Fatalf("final BytesDone = %d, want BytesTotal %d", last.BytesDone, last.BytesTotal)
+	}
+}
+
 func unpackAndPackArchive(prefix, testPrefix string) error {
 	srcTar := filepath.Join(dataDir, dataTar)
 	dstDir := filepath.Join(dataDir, testPrefix)